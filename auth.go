@@ -0,0 +1,132 @@
+package shadowsocks
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Auth is implemented by pluggable authentication backends. A Handler may
+// compose several backends (see AuthList); a request is authenticated if
+// any one of them validates it.
+type Auth interface {
+	// Validate reports whether r carries valid proxy credentials for this
+	// backend.
+	Validate(r *http.Request) bool
+
+	// Provision sets up the backend, e.g. loading files or dialing a
+	// management API.
+	Provision(ctx caddy.Context) error
+
+	// Stop releases any resources acquired in Provision, e.g. background
+	// reload goroutines or file watchers.
+	Stop() error
+}
+
+// subjectAuth is implemented by backends that can name the identity
+// behind a validated request, e.g. a htpasswd username or a certificate
+// CN. It is only used to enrich access log entries.
+type subjectAuth interface {
+	Subject(r *http.Request) string
+}
+
+// AuthConstructor builds an Auth backend from the URI that named it, e.g.
+// outline://127.0.0.1:8081/prefix. The scheme has already been matched to
+// the constructor registered under RegisterAuthBackend; the constructor
+// only needs to interpret the rest of the URI.
+type AuthConstructor func(u *url.URL) (Auth, error)
+
+var authBackends = struct {
+	mutex sync.RWMutex
+	m     map[string]AuthConstructor
+}{m: make(map[string]AuthConstructor)}
+
+// RegisterAuthBackend makes an Auth backend available under auth URIs of
+// the given scheme, e.g. RegisterAuthBackend("outline", newOutlineAuth).
+// It is meant to be called from an init function, including by packages
+// outside this module that wish to add their own backends.
+func RegisterAuthBackend(scheme string, ctor AuthConstructor) {
+	authBackends.mutex.Lock()
+	defer authBackends.mutex.Unlock()
+	authBackends.m[scheme] = ctor
+}
+
+// newAuthBackend parses raw as a URI and dispatches to the constructor
+// registered for its scheme.
+func newAuthBackend(raw string) (Auth, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("parse auth uri %q: %w", raw, err)
+	}
+
+	authBackends.mutex.RLock()
+	ctor, ok := authBackends.m[u.Scheme]
+	authBackends.mutex.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("unknown auth backend scheme %q in %q", u.Scheme, raw)
+	}
+
+	backend, err := ctor(u)
+	if err != nil {
+		return nil, "", err
+	}
+	return backend, u.Scheme, nil
+}
+
+// AuthList is one or more auth backend URIs. It unmarshals from either a
+// single JSON string or an array of strings, so Handler.Auth can be
+// written as auth: "outline://..." or auth: ["outline://...", "htpasswd:///path"].
+type AuthList []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *AuthList) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = AuthList{single}
+		return nil
+	}
+
+	var multi []string
+	if err := json.Unmarshal(b, &multi); err != nil {
+		return err
+	}
+	*a = AuthList(multi)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a AuthList) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// parseProxyBasicAuth decodes a "Basic <base64>" Proxy-Authorization
+// header into its user and password parts. Unlike (*http.Request).BasicAuth,
+// which only reads the Authorization header, this works on
+// Proxy-Authorization.
+func parseProxyBasicAuth(auth string) (user, password string, ok bool) {
+	const prefix = "Basic "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+
+	s := string(raw)
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
@@ -0,0 +1,141 @@
+package shadowsocks
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	RegisterAuthBackend("mtls", newCertAuth)
+}
+
+// certAuth authenticates the CONNECT request from the client TLS
+// certificate presented to the front Caddy site (tls { client_auth {
+// mode require_and_verify } }) instead of Proxy-Authorization, e.g.
+// mtls://?fingerprint=<sha256 hex>&ca=/etc/caddy/ca.pem&cn=client1. A
+// peer cert is accepted if it matches an allowed fingerprint or chains
+// to an allowed CA, and, when cn is set, its Subject CN or a DNS SAN
+// entry is in the allowlist; IP, email and URI SANs are not checked.
+type certAuth struct {
+	fingerprints map[string]struct{}
+	names        map[string]struct{}
+	caPool       *x509.CertPool
+}
+
+func newCertAuth(u *url.URL) (Auth, error) {
+	q := u.Query()
+
+	a := &certAuth{
+		fingerprints: make(map[string]struct{}),
+		names:        make(map[string]struct{}),
+	}
+	for _, fp := range q["fingerprint"] {
+		a.fingerprints[normalizeFingerprint(fp)] = struct{}{}
+	}
+	for _, name := range q["cn"] {
+		a.names[name] = struct{}{}
+	}
+
+	if ca := q.Get("ca"); ca != "" {
+		data, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("mtls auth: read ca bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("mtls auth: no certificates found in %v", ca)
+		}
+		a.caPool = pool
+	}
+
+	if len(a.fingerprints) == 0 && a.caPool == nil {
+		return nil, errors.New("mtls auth: at least one fingerprint or ca bundle must be configured")
+	}
+	return a, nil
+}
+
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+}
+
+// Provision implements Auth.
+func (a *certAuth) Provision(ctx caddy.Context) error {
+	return nil
+}
+
+// Stop implements Auth.
+func (a *certAuth) Stop() error {
+	return nil
+}
+
+// Validate implements Auth.
+func (a *certAuth) Validate(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+
+	// Only PeerCertificates[0] is cryptographically tied to this
+	// handshake: the client proved possession of its private key.
+	// Anything after it is unauthenticated data the client attached to
+	// its chain, so it must only ever be used to build the Intermediates
+	// pool for verifying the leaf, never matched against directly.
+	leaf := r.TLS.PeerCertificates[0]
+
+	var intermediates *x509.CertPool
+	if a.caPool != nil && len(r.TLS.PeerCertificates) > 1 {
+		intermediates = x509.NewCertPool()
+		for _, cert := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	return a.certAllowed(leaf, intermediates) && a.nameAllowed(leaf)
+}
+
+func (a *certAuth) certAllowed(cert *x509.Certificate, intermediates *x509.CertPool) bool {
+	if len(a.fingerprints) > 0 {
+		sum := sha256.Sum256(cert.Raw)
+		if _, ok := a.fingerprints[hex.EncodeToString(sum[:])]; ok {
+			return true
+		}
+	}
+	if a.caPool != nil {
+		opts := x509.VerifyOptions{Roots: a.caPool, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+		if _, err := cert.Verify(opts); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *certAuth) nameAllowed(cert *x509.Certificate) bool {
+	if len(a.names) == 0 {
+		return true
+	}
+	if _, ok := a.names[cert.Subject.CommonName]; ok {
+		return true
+	}
+	for _, san := range cert.DNSNames {
+		if _, ok := a.names[san]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Subject implements subjectAuth.
+func (a *certAuth) Subject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
@@ -0,0 +1,234 @@
+package shadowsocks
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+
+	"github.com/tg123/go-htpasswd"
+)
+
+func init() {
+	RegisterAuthBackend("htpasswd", newHtpasswdAuth)
+}
+
+const (
+	defaultReloadInterval          = time.Minute
+	defaultBcryptCostWarnThreshold = 10
+)
+
+var bcryptCostPattern = regexp.MustCompile(`\$2[aby]\$(\d+)\$`)
+
+// htpasswdAuth validates the standard HTTP Basic credentials carried in
+// Proxy-Authorization against an htpasswd(1) formatted file, e.g.
+// htpasswd:///etc/caddy/ss.users. The file is reloaded whenever its mtime
+// changes, so operators can add or remove users without restarting Caddy.
+type htpasswdAuth struct {
+	path              string
+	reloadInterval    time.Duration
+	costWarnThreshold int
+
+	logger *zap.Logger
+	limit  *rate.Limiter
+
+	mutex   sync.RWMutex
+	file    *htpasswd.File
+	modTime time.Time
+	valid   map[[sha256.Size]byte]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newHtpasswdAuth(u *url.URL) (Auth, error) {
+	path := u.Path
+	if path == "" {
+		return nil, fmt.Errorf("htpasswd auth: missing file path in %v", u)
+	}
+
+	a := &htpasswdAuth{
+		path:              path,
+		reloadInterval:    defaultReloadInterval,
+		costWarnThreshold: defaultBcryptCostWarnThreshold,
+		valid:             make(map[[sha256.Size]byte]struct{}),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+
+	q := u.Query()
+	if v := q.Get("reload_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("htpasswd auth: invalid reload_interval %q: %w", v, err)
+		}
+		a.reloadInterval = d
+	}
+	if v := q.Get("bcrypt_cost_warn_threshold"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("htpasswd auth: invalid bcrypt_cost_warn_threshold %q: %w", v, err)
+		}
+		a.costWarnThreshold = n
+	}
+	return a, nil
+}
+
+// Provision implements Auth.
+func (a *htpasswdAuth) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger()
+	a.limit = rate.NewLimiter(rate.Every(time.Second), 5)
+
+	if err := a.reload(); err != nil {
+		return err
+	}
+	go a.watch()
+	return nil
+}
+
+// Stop implements Auth.
+func (a *htpasswdAuth) Stop() error {
+	close(a.stop)
+	<-a.done
+	return nil
+}
+
+func (a *htpasswdAuth) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := htpasswd.New(a.path, htpasswd.DefaultSystems, func(err error) {
+		a.logger.Warn(fmt.Sprintf("htpasswd parse warning: %v", err))
+	})
+	if err != nil {
+		return err
+	}
+	a.warnWeakBcryptCosts()
+
+	a.mutex.Lock()
+	a.file = file
+	a.modTime = info.ModTime()
+	a.valid = make(map[[sha256.Size]byte]struct{})
+	a.mutex.Unlock()
+	return nil
+}
+
+// warnWeakBcryptCosts logs a warning for any bcrypt entry in the htpasswd
+// file whose cost factor is below costWarnThreshold.
+func (a *htpasswdAuth) warnWeakBcryptCosts() {
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		m := bcryptCostPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		cost, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		if cost < a.costWarnThreshold {
+			a.logger.Warn(fmt.Sprintf("htpasswd entry uses bcrypt cost %d, below warn threshold %d: %v", cost, a.costWarnThreshold, a.path))
+		}
+	}
+}
+
+func (a *htpasswdAuth) watch() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(a.path)
+			if err != nil {
+				a.logger.Error(fmt.Sprintf("stat htpasswd file error: %v", err))
+				continue
+			}
+
+			a.mutex.RLock()
+			changed := !info.ModTime().Equal(a.modTime)
+			a.mutex.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := a.reload(); err != nil {
+				a.logger.Error(fmt.Sprintf("reload htpasswd file error: %v", err))
+				continue
+			}
+			a.logger.Info(fmt.Sprintf("reloaded htpasswd file: %v", a.path))
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Validate implements Auth.
+//
+// A successful match is cached by a hash of the credential so that a
+// client reconnecting with the same user/password skips the limiter and
+// the bcrypt cost. Every reload of the file clears that cache. Only a
+// lookup that isn't in the cache consumes from the limiter, so the
+// limiter throttles credentials not yet seen as valid since the last
+// reload, never repeat traffic already in the cache.
+func (a *htpasswdAuth) Validate(r *http.Request) bool {
+	user, password, ok := parseProxyBasicAuth(r.Header.Get("Proxy-Authorization"))
+	if !ok {
+		return false
+	}
+	key := credentialKey(user, password)
+
+	a.mutex.RLock()
+	file := a.file
+	_, cached := a.valid[key]
+	a.mutex.RUnlock()
+	if file == nil {
+		return false
+	}
+	if cached {
+		return true
+	}
+
+	if !a.limit.Allow() {
+		return false
+	}
+	if !file.Match(user, password) {
+		return false
+	}
+
+	a.mutex.Lock()
+	a.valid[key] = struct{}{}
+	a.mutex.Unlock()
+	return true
+}
+
+func credentialKey(user, password string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(user + "\x00" + password))
+}
+
+// Subject implements subjectAuth.
+func (a *htpasswdAuth) Subject(r *http.Request) string {
+	user, _, ok := parseProxyBasicAuth(r.Header.Get("Proxy-Authorization"))
+	if !ok {
+		return ""
+	}
+	return user
+}
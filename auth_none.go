@@ -0,0 +1,34 @@
+package shadowsocks
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	RegisterAuthBackend("none", newNoneAuth)
+}
+
+// noneAuth disables authentication, accepting every request, when named
+// as auth: "none://". Leaving Handler.Auth empty (with no ShadowBox or
+// Users) does not do this: with no backend configured, every CONNECT
+// request fails authentication and falls through to the next handler.
+type noneAuth struct{}
+
+func newNoneAuth(*url.URL) (Auth, error) {
+	return noneAuth{}, nil
+}
+
+func (noneAuth) Validate(*http.Request) bool {
+	return true
+}
+
+func (noneAuth) Provision(ctx caddy.Context) error {
+	return nil
+}
+
+func (noneAuth) Stop() error {
+	return nil
+}
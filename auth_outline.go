@@ -0,0 +1,102 @@
+package shadowsocks
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+
+	"github.com/imgk/caddy-shadowsocks-tls/outline"
+)
+
+func init() {
+	RegisterAuthBackend("outline", newOutlineAuth)
+}
+
+// outlineAuth validates Proxy-Authorization headers against the access
+// keys reported by an Outline Server management API, e.g.
+// outline://127.0.0.1:8081/prefix. On a miss it refreshes its local cache
+// from the API, rate-limited so a flood of bad credentials can't be used
+// to hammer it.
+type outlineAuth struct {
+	api string
+
+	logger *zap.Logger
+	limit  *rate.Limiter
+	mutex  sync.RWMutex
+	users  map[string]struct{}
+}
+
+func newOutlineAuth(u *url.URL) (Auth, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("outline auth: missing host in %v", u)
+	}
+	return &outlineAuth{
+		api:   "https://" + u.Host + u.Path,
+		users: make(map[string]struct{}),
+	}, nil
+}
+
+// Provision implements Auth.
+func (a *outlineAuth) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger()
+	a.limit = rate.NewLimiter(rate.Every(time.Second), 1)
+	return a.reload()
+}
+
+// Stop implements Auth.
+func (a *outlineAuth) Stop() error {
+	return nil
+}
+
+func (a *outlineAuth) reload() error {
+	server, err := outline.NewOutlineServer(a.api)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]struct{}, len(server.Users))
+	for _, user := range server.Users {
+		a.logger.Info(fmt.Sprintf("add new user: %v", user.Password))
+		users[GenKey(user.Password)] = struct{}{}
+	}
+
+	a.mutex.Lock()
+	a.users = users
+	a.mutex.Unlock()
+	return nil
+}
+
+// Validate implements Auth.
+func (a *outlineAuth) Validate(r *http.Request) bool {
+	auth := r.Header.Get("Proxy-Authorization")
+	if AuthLen != len(auth) {
+		return false
+	}
+
+	a.mutex.RLock()
+	_, ok := a.users[auth]
+	a.mutex.RUnlock()
+	if ok {
+		return true
+	}
+
+	if !a.limit.Allow() {
+		return false
+	}
+	if err := a.reload(); err != nil {
+		a.logger.Error(fmt.Sprintf("reload outline server error: %v", err))
+		return false
+	}
+
+	a.mutex.RLock()
+	_, ok = a.users[auth]
+	a.mutex.RUnlock()
+	return ok
+}
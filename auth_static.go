@@ -0,0 +1,51 @@
+package shadowsocks
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	RegisterAuthBackend("static", newStaticAuth)
+}
+
+// staticAuth validates Proxy-Authorization headers against a fixed list
+// of passwords supplied inline in the config, e.g.
+// static://?user=alice&password=hunter2&user=bob&password=hunter3. The
+// wire credential is a prehash of the password alone (see GenKey), so
+// user is carried only for operators' own bookkeeping.
+type staticAuth struct {
+	users map[string]struct{}
+}
+
+func newStaticAuth(u *url.URL) (Auth, error) {
+	passwords := u.Query()["password"]
+	if len(passwords) == 0 {
+		return nil, errors.New("static auth: no password supplied")
+	}
+
+	users := make(map[string]struct{}, len(passwords))
+	for _, password := range passwords {
+		users[GenKey(password)] = struct{}{}
+	}
+	return &staticAuth{users: users}, nil
+}
+
+// Provision implements Auth.
+func (a *staticAuth) Provision(ctx caddy.Context) error {
+	return nil
+}
+
+// Stop implements Auth.
+func (a *staticAuth) Stop() error {
+	return nil
+}
+
+// Validate implements Auth.
+func (a *staticAuth) Validate(r *http.Request) bool {
+	_, ok := a.users[r.Header.Get("Proxy-Authorization")]
+	return ok
+}
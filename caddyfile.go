@@ -0,0 +1,109 @@
+package shadowsocks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	httpcaddyfile.RegisterHandlerDirective("shadowsocks_tls", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("shadowsocks_tls", httpcaddyfile.Before, "reverse_proxy")
+}
+
+// parseCaddyfile unmarshals tokens from h into a new Handler.
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := new(Handler)
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	return m, err
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+//
+//	shadowsocks_tls {
+//	    server 127.0.0.1:8388
+//	    shadowbox https://127.0.0.1:8081/prefix
+//	    user alice
+//	    user bob
+//	    auth htpasswd /etc/caddy/ss.users
+//	    udp_timeout 3m
+//	}
+func (m *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "server":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Server = d.Val()
+			case "shadowbox":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.ShadowBox = d.Val()
+			case "user":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Users = append(m.Users, d.Val())
+			case "auth":
+				args := d.RemainingArgs()
+				uri, err := authCaddyfileArgs(args)
+				if err != nil {
+					return d.Errf("%v", err)
+				}
+				m.Auth = append(m.Auth, uri)
+			case "udp_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				timeout, err := time.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid udp_timeout: %v", err)
+				}
+				m.UDPTimeout = caddy.Duration(timeout)
+			default:
+				return d.Errf("unrecognized subdirective %q", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// authCaddyfileArgs turns the arguments of an "auth" subdirective into an
+// auth backend URI. The first argument may already be a full URI
+// (outline://127.0.0.1:8081/prefix), or a bare scheme optionally followed
+// by a path (htpasswd /etc/caddy/ss.users -> htpasswd:///etc/caddy/ss.users).
+// Backends that need more than a scheme and a path (e.g. static's
+// repeated user/password pairs) must be spelled out as a full URI so no
+// token is ever silently dropped.
+func authCaddyfileArgs(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("auth: expected a backend URI or scheme")
+	}
+	if strings.Contains(args[0], "://") {
+		if len(args) > 1 {
+			return "", fmt.Errorf("auth: unexpected extra argument(s) after URI %q: %v", args[0], args[1:])
+		}
+		return args[0], nil
+	}
+	if len(args) > 2 {
+		return "", fmt.Errorf("auth: unexpected extra argument(s) after %q %q: %v", args[0], args[1], args[2:])
+	}
+
+	path := ""
+	if len(args) > 1 {
+		path = args[1]
+	}
+	return fmt.Sprintf("%s://%s", args[0], path), nil
+}
+
+// Interface guard
+var _ caddyfile.Unmarshaler = (*Handler)(nil)
@@ -0,0 +1,164 @@
+package shadowsocks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	_ "github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddytest"
+)
+
+// TestUnmarshalCaddyfile checks that the shadowsocks_tls Caddyfile
+// directive adapts to the same config a hand-written JSON document would
+// produce. The comparison is done on decoded JSON values rather than raw
+// bytes, since the adapter's key order and whitespace are an
+// implementation detail, not part of the directive's contract.
+func TestUnmarshalCaddyfile(t *testing.T) {
+	rawConfig := `
+	:18080 {
+		shadowsocks_tls {
+			server 127.0.0.1:8388
+			shadowbox https://127.0.0.1:8081/prefix
+			user alice
+			user bob
+			auth htpasswd /etc/caddy/ss.users
+			udp_timeout 3m
+		}
+	}
+	`
+
+	adapter := caddyconfig.GetAdapter("caddyfile")
+	if adapter == nil {
+		t.Fatal("caddyfile adapter not registered")
+	}
+
+	result, warnings, err := adapter.Adapt([]byte(rawConfig), nil)
+	if err != nil {
+		t.Fatalf("adapt caddyfile: %v", err)
+	}
+	for _, w := range warnings {
+		t.Logf("adapt warning: %+v", w)
+	}
+
+	expected := `{
+		"apps": {
+			"http": {
+				"servers": {
+					"srv0": {
+						"listen": [":18080"],
+						"routes": [
+							{
+								"handle": [
+									{
+										"handler": "shadowsocks_tls",
+										"server": "127.0.0.1:8388",
+										"shadowbox": "https://127.0.0.1:8081/prefix",
+										"users": ["alice", "bob"],
+										"auth": "htpasswd:///etc/caddy/ss.users",
+										"udp_timeout": 180000000000
+									}
+								]
+							}
+						]
+					}
+				}
+			}
+		}
+	}`
+
+	var got, want interface{}
+	if err := json.Unmarshal(result, &got); err != nil {
+		t.Fatalf("unmarshal adapted config: %v", err)
+	}
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		t.Fatalf("unmarshal expected config: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		gotPretty, _ := json.MarshalIndent(got, "", "\t")
+		wantPretty, _ := json.MarshalIndent(want, "", "\t")
+		t.Errorf("adapted config does not match hand-written JSON\ngot:\n%s\nwant:\n%s", gotPretty, wantPretty)
+	}
+}
+
+// TestShadowsocksTLSServesTCP exercises the handler end to end: a
+// Caddyfile-configured shadowsocks_tls directive with auth disabled
+// should tunnel a CONNECT request through to the configured upstream.
+func TestShadowsocksTLSServesTCP(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen upstream: %v", err)
+	}
+	defer upstream.Close()
+
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+
+	tester := caddytest.NewTester(t)
+	tester.InitServer(fmt.Sprintf(`
+	{
+		skip_install_trust
+		admin localhost:2999
+	}
+	:18081 {
+		shadowsocks_tls {
+			server %s
+			auth none
+		}
+	}
+	`, upstream.Addr().String()), "caddyfile")
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:18081", 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial caddy: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "CONNECT / HTTP/1.1\r\nHost: tcp.upstream:0\r\n\r\n"); err != nil {
+		t.Fatalf("write CONNECT: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read status line: %v", err)
+	}
+	if !strings.Contains(status, "200") {
+		t.Fatalf("unexpected status line: %q", status)
+	}
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("read trailing CRLF: %v", err)
+	}
+
+	want := []byte("ping")
+	if _, err := conn.Write(want); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+
+	got := make([]byte, len(want))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(reader, got); err != nil {
+		t.Fatalf("read echoed payload: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("echoed payload = %q, want %q", got, want)
+	}
+}
@@ -11,14 +11,13 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"reflect"
-	"sync"
+	"strings"
 	"time"
 	"unsafe"
 
-	"golang.org/x/time/rate"
-
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 
@@ -33,14 +32,18 @@ func init() {
 
 // Handler implements an HTTP handler that ...
 type Handler struct {
-	Server    string   `json:"server,omitempty"`
-	ShadowBox string   `json:"shadowbox,omitempty"`
-	Users     []string `json:"users,omitempty"`
+	Server         string         `json:"server,omitempty"`
+	ShadowBox      string         `json:"shadowbox,omitempty"`
+	Users          []string       `json:"users,omitempty"`
+	Auth           AuthList       `json:"auth,omitempty"`
+	UDPTimeout     caddy.Duration `json:"udp_timeout,omitempty"`
+	MetricsEnabled bool           `json:"metrics_enabled,omitempty"`
+	AccessLog      bool           `json:"access_log,omitempty"`
 
 	logger *zap.Logger
-	limit  *rate.Limiter
-	mutex  *sync.RWMutex
-	users  map[string]struct{}
+
+	auth    []Auth
+	schemes []string
 
 	proxyIP   net.IP
 	proxyPort int
@@ -57,8 +60,10 @@ func (Handler) CaddyModule() caddy.ModuleInfo {
 // Provision implements caddy.Provisioner.
 func (m *Handler) Provision(ctx caddy.Context) (err error) {
 	m.logger = ctx.Logger(m)
-	m.mutex = new(sync.RWMutex)
-	m.users = make(map[string]struct{})
+
+	if m.MetricsEnabled {
+		registerMetrics()
+	}
 
 	prefix := os.Getenv("SB_API_PREFIX")
 	port := os.Getenv("SB_API_PORT")
@@ -67,23 +72,31 @@ func (m *Handler) Provision(ctx caddy.Context) (err error) {
 		m.logger.Info(fmt.Sprintf("add shadowbox server: %v", m.ShadowBox))
 	}
 
+	// ShadowBox and Users are sugar for the outline:// and static://
+	// backends, kept for backward compatibility with existing configs.
 	if m.ShadowBox != "" {
 		server, er := outline.NewOutlineServer(m.ShadowBox)
 		if er != nil {
 			err = er
 			return
 		}
-
 		if m.Server == "" {
 			m.Server = fmt.Sprintf("127.0.0.1:%v", server.PortForNewAccessKeys)
 		}
 
-		m.logger.Info("add user from shadowbox server")
-		for _, user := range server.Users {
-			m.logger.Info(fmt.Sprintf("add new user: %v", user.Password))
-			m.users[GenKey(user.Password)] = struct{}{}
+		shadowbox, er := url.Parse(m.ShadowBox)
+		if er != nil {
+			err = er
+			return
+		}
+		m.Auth = append(m.Auth, (&url.URL{Scheme: "outline", Host: shadowbox.Host, Path: shadowbox.Path}).String())
+	}
+	if len(m.Users) > 0 {
+		values := url.Values{}
+		for _, user := range m.Users {
+			values.Add("password", user)
 		}
-		m.limit = rate.NewLimiter(rate.Every(time.Second), 1)
+		m.Auth = append(m.Auth, (&url.URL{Scheme: "static", RawQuery: values.Encode()}).String())
 	}
 
 	proxyAddr, err := net.ResolveTCPAddr("tcp", m.Server)
@@ -93,13 +106,39 @@ func (m *Handler) Provision(ctx caddy.Context) (err error) {
 	m.proxyIP = proxyAddr.IP
 	m.proxyPort = proxyAddr.Port
 
-	for _, user := range m.Users {
-		m.logger.Info(fmt.Sprintf("add new user: %v", user))
-		m.users[GenKey(user)] = struct{}{}
+	for _, raw := range m.Auth {
+		backend, scheme, er := newAuthBackend(raw)
+		if er != nil {
+			err = er
+			return
+		}
+		if er := backend.Provision(ctx); er != nil {
+			err = er
+			if cleanupErr := m.Cleanup(); cleanupErr != nil {
+				m.logger.Error(fmt.Sprintf("cleanup after provision error: %v", cleanupErr))
+			}
+			return
+		}
+		m.auth = append(m.auth, backend)
+		m.schemes = append(m.schemes, scheme)
 	}
 	return
 }
 
+// Cleanup implements caddy.CleanerUpper.
+func (m *Handler) Cleanup() error {
+	var errs []string
+	for _, backend := range m.auth {
+		if err := backend.Stop(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 // ServeHTTP implements caddyhttp.MiddlewareHandler.
 func (m *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	if r.Method != http.MethodConnect {
@@ -139,15 +178,15 @@ func (m *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyht
 
 	switch r.Host[:4] {
 	case "tcp.":
+		rw, tracked, start := m.wrapSession(rw, "tcp")
 		m.logger.Info(fmt.Sprintf("handle tcp connection from %v", r.RemoteAddr))
-		if err := HandleTCP(rw, &net.TCPAddr{IP: m.proxyIP, Port: m.proxyPort}); err != nil {
-			m.logger.Error(fmt.Sprintf("handle tcp error: %v", err))
-		}
+		err := HandleTCP(rw, &net.TCPAddr{IP: m.proxyIP, Port: m.proxyPort})
+		m.finishSession("tcp", r, tracked, start, err)
 	case "udp.":
+		rw, tracked, start := m.wrapSession(rw, "udp")
 		m.logger.Info(fmt.Sprintf("handle udp connection from %v", r.RemoteAddr))
-		if err := HandleUDP(rw, &net.UDPAddr{IP: m.proxyIP, Port: m.proxyPort}, time.Minute*3); err != nil {
-			m.logger.Error(fmt.Sprintf("handle udp error: %v", err))
-		}
+		err := HandleUDP(rw, &net.UDPAddr{IP: m.proxyIP, Port: m.proxyPort}, m.udpTimeout())
+		m.finishSession("udp", r, tracked, start, err)
 	default:
 		if _, ok := w.(http.Hijacker); !ok {
 			return next.ServeHTTP(w, r)
@@ -157,9 +196,59 @@ func (m *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyht
 	return nil
 }
 
+// wrapSession records the start time and, when metrics or access logging
+// are enabled, wraps rw to count bytes transferred. Its trackedConn
+// forwards CloseWrite through a plain interface assertion, so it doesn't
+// defeat the rawConn fast-path HandleTCP relies on.
+func (m *Handler) wrapSession(rw io.ReadWriter, transport string) (io.ReadWriter, *trackedConn, time.Time) {
+	start := time.Now()
+	if !m.MetricsEnabled && !m.AccessLog {
+		return rw, nil, start
+	}
+
+	tracked := &trackedConn{ReadWriter: rw}
+	if m.MetricsEnabled {
+		activeSessions.WithLabelValues(transport).Inc()
+	}
+	return tracked, tracked, start
+}
+
+// finishSession logs a HandleTCP/HandleUDP error, if any, and, when
+// metrics or access logging are enabled, reports the session's byte
+// counts and duration.
+func (m *Handler) finishSession(transport string, r *http.Request, tracked *trackedConn, start time.Time, err error) {
+	if err != nil {
+		m.logger.Error(fmt.Sprintf("handle %v error: %v", transport, err))
+	}
+	if tracked == nil {
+		return
+	}
+
+	duration := time.Since(start)
+	if m.MetricsEnabled {
+		activeSessions.WithLabelValues(transport).Dec()
+		bytesTotal.WithLabelValues(transport, "in").Add(float64(tracked.bytesIn))
+		bytesTotal.WithLabelValues(transport, "out").Add(float64(tracked.bytesOut))
+		sessionDuration.WithLabelValues(transport).Observe(duration.Seconds())
+	}
+	if m.AccessLog {
+		m.logger.Info("proxied connection closed",
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("subject", m.subject(r)),
+			zap.String("transport", transport),
+			zap.String("upstream", fmt.Sprintf("%v:%v", m.proxyIP, m.proxyPort)),
+			zap.Uint64("bytes_in", tracked.bytesIn),
+			zap.Uint64("bytes_out", tracked.bytesOut),
+			zap.Duration("duration", duration),
+			zap.String("error_class", errorClass(err)),
+		)
+	}
+}
+
 // Interface guards
 var (
 	_ caddy.Provisioner           = (*Handler)(nil)
+	_ caddy.CleanerUpper          = (*Handler)(nil)
 	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
 )
 
@@ -184,52 +273,63 @@ func GenKey(s string) string {
 // len(GenKey("Test1234"))
 const AuthLen = 82
 
+// authenticate reports whether r is authorized by any configured auth
+// backend; Handler composes backends, so a single match is enough.
 func (m *Handler) authenticate(r *http.Request) bool {
-	auth := r.Header.Get("Proxy-Authorization")
-	m.mutex.RLock()
-	_, ok := m.users[auth]
-	m.mutex.RUnlock()
-
-	if ok {
-		return true
+	for i, backend := range m.auth {
+		if backend.Validate(r) {
+			if m.MetricsEnabled {
+				authAttemptsTotal.WithLabelValues(m.schemes[i], "accepted").Inc()
+			}
+			return true
+		}
 	}
-	if AuthLen != len(auth) || m.ShadowBox == "" {
-		return false
+	if m.MetricsEnabled {
+		authAttemptsTotal.WithLabelValues("none", "rejected").Inc()
 	}
+	return false
+}
 
-	m.mutex.Lock()
-	if _, ok = m.users[auth]; ok {
-		m.mutex.Unlock()
-		return true
+// subject returns a human-readable identity for a validated request, for
+// use in access log entries only: the first configured backend that can
+// name one (see subjectAuth) wins, falling back to a truncated prehash
+// of the Proxy-Authorization header.
+func (m *Handler) subject(r *http.Request) string {
+	for _, backend := range m.auth {
+		if sa, ok := backend.(subjectAuth); ok {
+			if s := sa.Subject(r); s != "" {
+				return s
+			}
+		}
 	}
-	if !m.limit.Allow() {
-		m.mutex.Unlock()
-		return false
+	if auth := r.Header.Get("Proxy-Authorization"); auth != "" {
+		return fmt.Sprintf("key:%.12s", auth)
 	}
+	return "-"
+}
 
-	server, err := outline.NewOutlineServer(m.ShadowBox)
-	if err != nil {
-		m.logger.Error(fmt.Sprintf("connect shadowbox error: %v", err))
-		return false
+// errorClass buckets an error from HandleTCP/HandleUDP for access logs.
+func errorClass(err error) string {
+	switch {
+	case err == nil:
+		return "none"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, io.EOF):
+		return "eof"
+	default:
+		return "error"
 	}
+}
 
-	for user := range m.users {
-		delete(m.users, user)
-	}
-	for _, user := range server.Users {
-		m.logger.Info(fmt.Sprintf("add new user: %v", user.Password))
-		m.users[GenKey(user.Password)] = struct{}{}
-	}
-	for _, user := range m.Users {
-		m.logger.Info(fmt.Sprintf("add new user: %v", user))
-		m.users[GenKey(user)] = struct{}{}
-	}
-	m.mutex.Unlock()
+// defaultUDPTimeout is used when UDPTimeout is unset.
+const defaultUDPTimeout = time.Minute * 3
 
-	m.mutex.RLock()
-	_, ok = m.users[auth]
-	m.mutex.RUnlock()
-	return ok
+func (m *Handler) udpTimeout() time.Duration {
+	if m.UDPTimeout == 0 {
+		return defaultUDPTimeout
+	}
+	return time.Duration(m.UDPTimeout)
 }
 
 type rwConn struct {
@@ -309,7 +409,7 @@ func HandleTCP(rw io.ReadWriter, raddr *net.TCPAddr) error {
 
 	_, err = io.Copy(rw, io.Reader(rc))
 	if err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
-		if conn, ok := rw.(*rawConn); ok {
+		if conn, ok := rw.(interface{ CloseWrite() error }); ok {
 			conn.CloseWrite()
 		}
 		rc.CloseRead()
@@ -0,0 +1,84 @@
+package shadowsocks
+
+import (
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var metricsOnce sync.Once
+
+var (
+	authAttemptsTotal *prometheus.CounterVec
+	activeSessions    *prometheus.GaugeVec
+	bytesTotal        *prometheus.CounterVec
+	sessionDuration   *prometheus.HistogramVec
+)
+
+// registerMetrics lazily creates and registers the module's Prometheus
+// collectors against the default registry, which Caddy's admin metrics
+// endpoint scrapes. It is safe to call from every Handler instance.
+func registerMetrics() {
+	metricsOnce.Do(func() {
+		authAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "caddy",
+			Subsystem: "shadowsocks_tls",
+			Name:      "auth_attempts_total",
+			Help:      "Count of authentication attempts, labeled by backend scheme and result.",
+		}, []string{"backend", "result"})
+
+		activeSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "caddy",
+			Subsystem: "shadowsocks_tls",
+			Name:      "active_sessions",
+			Help:      "Number of proxied sessions currently open, labeled by transport.",
+		}, []string{"transport"})
+
+		bytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "caddy",
+			Subsystem: "shadowsocks_tls",
+			Name:      "bytes_total",
+			Help:      "Bytes proxied, labeled by transport and direction.",
+		}, []string{"transport", "direction"})
+
+		sessionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "caddy",
+			Subsystem: "shadowsocks_tls",
+			Name:      "session_duration_seconds",
+			Help:      "Duration of proxied sessions, labeled by transport.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"transport"})
+
+		prometheus.MustRegister(authAttemptsTotal, activeSessions, bytesTotal, sessionDuration)
+	})
+}
+
+// trackedConn wraps a Handler's hijacked rw to count bytes transferred in
+// each direction. It forwards CloseWrite to the wrapped connection
+// through a plain interface assertion, rather than the assertion
+// observing HandleTCP's own rawConn type, so the fast-path half-close
+// behavior HandleTCP relies on isn't lost behind the wrapper.
+type trackedConn struct {
+	io.ReadWriter
+	bytesIn, bytesOut uint64
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.ReadWriter.Read(b)
+	c.bytesIn += uint64(n)
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.ReadWriter.Write(b)
+	c.bytesOut += uint64(n)
+	return n, err
+}
+
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.ReadWriter.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}